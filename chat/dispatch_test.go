@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWantsRawPostCurlDefault reproduces `curl -d "hello" http://host/`,
+// which sends Content-Type: application/x-www-form-urlencoded (curl's
+// default for -d) and no special Accept header. It must be treated as a
+// raw post since "hello" doesn't decode to the HTML form's "msg" field.
+func TestWantsRawPostCurlDefault(t *testing.T) {
+	body := []byte("hello")
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !wantsRawPost(r, body) {
+		t.Fatal("want raw post for curl -d \"hello\"")
+	}
+}
+
+// TestWantsRawPostHTMLForm ensures an actual HTML form submission, which
+// uses the same Content-Type as curl's default, is still dispatched to the
+// HTML handler.
+func TestWantsRawPostHTMLForm(t *testing.T) {
+	body := []byte("msg=hello")
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if wantsRawPost(r, body) {
+		t.Fatal("want HTML form post to not be treated as raw")
+	}
+}
+
+// TestWantsRawPostExplicitContentType ensures an explicit text/plain
+// Content-Type is always treated as raw, regardless of body shape.
+func TestWantsRawPostExplicitContentType(t *testing.T) {
+	body := []byte("msg=hello")
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "text/plain")
+	if !wantsRawPost(r, body) {
+		t.Fatal("want raw post for explicit text/plain Content-Type")
+	}
+}
+
+// TestWantsRawPostEmptyHTMLForm reproduces submitting the HTML form with
+// nothing typed, which posts body "msg=". The empty value must still route
+// to the HTML handler (so it hits the "empty message" 400), not be
+// published verbatim as raw text.
+func TestWantsRawPostEmptyHTMLForm(t *testing.T) {
+	body := []byte("msg=")
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if wantsRawPost(r, body) {
+		t.Fatal("want empty HTML form post to not be treated as raw")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// HistoryStore persists chat updates so a room's timeline survives restarts
+// and reconnects. Implementations must be safe for concurrent use.
+type HistoryStore interface {
+	// Append stores a new update for room and returns it with its assigned,
+	// monotonically increasing ID.
+	Append(room, timestamp, message string) (*Update, error)
+	// Since returns up to sinceLimit updates for room with ID greater than
+	// sinceID, ordered oldest first, for a client resuming from a cursor.
+	// The returned bool reports whether more updates exist beyond what's
+	// returned, so the caller can have the client page forward by
+	// reconnecting with ?since=<last returned id> instead of the whole
+	// backlog being replayed in one unbounded request.
+	Since(room string, sinceID int64) (updates []*Update, truncated bool, err error)
+	// Latest returns the most recent n updates for room, ordered oldest
+	// first, for a client connecting without a cursor.
+	Latest(room string, n int) ([]*Update, error)
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// sinceLimit caps how many updates a single Since call returns, so an
+// unauthenticated client can't force a room's whole persisted history to be
+// loaded and streamed in one shot by reconnecting with a stale cursor.
+const sinceLimit = 500
+
+// SQLiteHistoryStore is a HistoryStore backed by a SQLite database file
+type SQLiteHistoryStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteHistoryStore opens (creating if necessary) a SQLite database at
+// path and returns a *SQLiteHistoryStore backed by it
+func NewSQLiteHistoryStore(path string) (*SQLiteHistoryStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS updates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			room TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			message TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_updates_room_id ON updates (room, id);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteHistoryStore{db: db}, nil
+}
+
+// Append implements HistoryStore
+func (s *SQLiteHistoryStore) Append(room, timestamp, message string) (*Update, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO updates (room, timestamp, message) VALUES (?, ?, ?)",
+		room, timestamp, message,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Update{id: id, timestamp: timestamp, message: message}, nil
+}
+
+// Since implements HistoryStore
+func (s *SQLiteHistoryStore) Since(room string, sinceID int64) ([]*Update, bool, error) {
+	rows, err := s.db.Query(
+		"SELECT id, timestamp, message FROM updates WHERE room = ? AND id > ? ORDER BY id ASC LIMIT ?",
+		room, sinceID, sinceLimit+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	updates, err := scanUpdates(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	truncated := len(updates) > sinceLimit
+	if truncated {
+		updates = updates[:sinceLimit]
+	}
+	return updates, truncated, nil
+}
+
+// Latest implements HistoryStore
+func (s *SQLiteHistoryStore) Latest(room string, n int) ([]*Update, error) {
+	rows, err := s.db.Query(
+		"SELECT id, timestamp, message FROM (SELECT id, timestamp, message FROM updates WHERE room = ? ORDER BY id DESC LIMIT ?) ORDER BY id ASC",
+		room, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanUpdates(rows)
+}
+
+// scanUpdates reads every row of rows into a slice of *Update and closes it
+func scanUpdates(rows *sql.Rows) ([]*Update, error) {
+	defer rows.Close()
+	updates := make([]*Update, 0)
+	for rows.Next() {
+		update := &Update{}
+		if err := rows.Scan(&update.id, &update.timestamp, &update.message); err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+// Close implements HistoryStore
+func (s *SQLiteHistoryStore) Close() error {
+	return s.db.Close()
+}
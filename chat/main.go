@@ -7,16 +7,22 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Number of updates to keep in history
+// Number of history updates replayed to a client that connects without a
+// cursor (no ?since= or Last-Event-ID)
 const historyLimit = 20
 
 // Interval to send single-space ping to keep conntection alive
@@ -25,9 +31,28 @@ const pingRate = 1 * time.Second
 // Maximum message length
 const maxMsgLen = 1024
 
+// Upper bound on a raw POST body, wide enough that an HTML form submission
+// of a maxMsgLen message is never truncated by the "msg=" field name and
+// worst-case percent-encoding of every byte before the message-length check
+// below runs
+const postBodyLimit = 4*maxMsgLen + 64
+
 // Number of buffered messages per connection
 const bufferSize = 5
 
+// Prefix for room URLs, e.g. /room/foo. Requests to "/" use the default room.
+const roomPrefix = "/room/"
+
+// Idle rooms (no listeners, no posts) are garbage-collected after this long
+const roomIdleTimeout = 10 * time.Minute
+
+// How often the GC sweep checks for idle rooms
+const roomGCInterval = time.Minute
+
+// Number of consecutive dropped sends before a subscriber is evicted, so it
+// reconnects and resyncs from history instead of falling permanently behind
+const missLimit = 3
+
 // Leading portion of main page
 const pageHead = `<!doctype html>
 <html>
@@ -48,120 +73,527 @@ const pageHead = `<!doctype html>
 `
 
 func main() {
-	app := NewApp()
+	dbPath := flag.String("db", "chat.db", "path to the sqlite database used for history")
+	postRate := flag.Float64("post-rate", 1, "sustained posts per second allowed per IP")
+	postBurst := flag.Int("post-burst", 5, "burst of posts allowed per IP")
+	flag.Parse()
+	store, err := NewSQLiteHistoryStore(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	limiter := NewRateLimiter(*postRate, *postBurst)
+	go limiter.gc()
+	app := NewApp(store, limiter)
+	go app.gcRooms()
 	http.HandleFunc("/", app.handler)
+	http.HandleFunc("/debug/stats", app.statsHandler)
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 	log.Println("Serving on :8080")
 	http.ListenAndServe(":8080", nil)
 }
 
-// Update represents a chat update
+// Update represents a chat update. id is assigned by the HistoryStore and
+// increases monotonically across every room.
 type Update struct {
+	id        int64
 	timestamp string
 	message   string
 }
 
-// App represents the main application
-type App struct {
-	chansMutex   sync.RWMutex
-	chans        map[chan []byte]struct{}
-	historyMutex sync.RWMutex
-	history      []*Update
+// Subscriber is a transport-agnostic sink for room events. Each connected
+// client, regardless of which transport it arrived on, is represented by one
+// Subscriber so Room only has to know how to fan events out, not how each
+// transport renders them.
+type Subscriber interface {
+	// Update delivers a newly posted message, reporting whether it was
+	// actually delivered (false if the subscriber's buffer was full).
+	Update(update *Update) bool
+	// Count delivers the room's current listener count, with the same
+	// delivery-reporting contract as Update.
+	Count(n int) bool
+	// Unhealthy reports whether the subscriber has missed missLimit
+	// consecutive deliveries and should be evicted.
+	Unhealthy() bool
+	// Close tears down the subscriber's underlying connection, used when
+	// Room evicts it for falling too far behind.
+	Close()
 }
 
-// NewApp returns a new *App
-func NewApp() *App {
-	return &App{
-		chans:   make(map[chan []byte]struct{}),
-		history: make([]*Update, 0),
+// backpressure tracks consecutive missed deliveries for a Subscriber so it
+// can be evicted once it falls too far behind rather than silently dropped
+// forever. Its closeMu also serializes delivery against Close: broadcast
+// can run concurrently for multiple posts, so without this two such calls
+// could race a send against an eviction's close of the same subscriber's
+// channel, or evict the same subscriber twice.
+type backpressure struct {
+	misses int32
+
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// recordDelivery updates the consecutive-miss count based on whether the
+// last delivery succeeded
+func (b *backpressure) recordDelivery(ok bool) {
+	if ok {
+		atomic.StoreInt32(&b.misses, 0)
+		return
 	}
+	atomic.AddInt32(&b.misses, 1)
+}
+
+// Unhealthy implements Subscriber
+func (b *backpressure) Unhealthy() bool {
+	return atomic.LoadInt32(&b.misses) >= missLimit
+}
+
+// guardedSend runs send while holding closeMu for reading, so it can't run
+// concurrently with guardedClose tearing down the same subscriber's
+// channel, and records the resulting delivery outcome. A subscriber that's
+// already closed reports a failed delivery without calling send.
+func (b *backpressure) guardedSend(send func() bool) bool {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+	ok := !b.closed && send()
+	b.recordDelivery(ok)
+	return ok
 }
 
-// append an *Update to the chat log
-func (a *App) append(update *Update) {
-	a.historyMutex.Lock()
-	defer a.historyMutex.Unlock()
-	a.history = append(a.history, update)
-	if len(a.history) > historyLimit {
-		a.history = a.history[len(a.history)-historyLimit:]
+// guardedClose runs closeFn at most once, excluding any in-flight
+// guardedSend, so a subscriber is never closed while being delivered to and
+// is never closed twice.
+func (b *backpressure) guardedClose(closeFn func()) {
+	b.closeMu.Lock()
+	defer b.closeMu.Unlock()
+	if b.closed {
+		return
 	}
+	b.closed = true
+	closeFn()
 }
 
-// sendCount sends the current connection count to all clients
-func (a *App) sendCount() {
-	fmtstr := "<style>#nc::before{content:\"%d\"}</style>"
-	data := []byte(fmt.Sprintf(fmtstr, len(a.chans)))
-	a.chansMutex.RLock()
-	defer a.chansMutex.RUnlock()
-	for ch := range a.chans {
-		select {
-		case ch <- data:
-		default:
-			continue
-		}
+// Room represents a single independent timeline with its own listeners,
+// addressed by a URL path such as /room/foo. History is delegated to a
+// shared HistoryStore, scoped by name.
+type Room struct {
+	name  string
+	store HistoryStore
+	stats *Stats
+
+	subsMutex sync.RWMutex
+	subs      map[Subscriber]struct{}
+
+	lastActiveMutex sync.RWMutex
+	lastActive      time.Time
+}
+
+// NewRoom returns a new *Room backed by store and scoped to name, reporting
+// observability counters to stats
+func NewRoom(name string, store HistoryStore, stats *Stats) *Room {
+	return &Room{
+		name:       name,
+		store:      store,
+		stats:      stats,
+		subs:       make(map[Subscriber]struct{}),
+		lastActive: time.Now(),
 	}
 }
 
-// send an *Update by appending it to the chat log and sending to clients
-func (a *App) send(update *Update) {
-	a.append(update)
-	fmtstr := "<div class=\"new\"><p>%s</p><time>%s</time></div>"
-	msg := fmt.Sprintf(fmtstr, update.message, update.timestamp)
-	data := []byte(msg)
-	a.chansMutex.RLock()
-	defer a.chansMutex.RUnlock()
-	for ch := range a.chans {
-		select {
-		case ch <- data:
-		default:
-			continue
+// touch marks the room as recently active so it isn't garbage-collected
+func (rm *Room) touch() {
+	rm.lastActiveMutex.Lock()
+	defer rm.lastActiveMutex.Unlock()
+	rm.lastActive = time.Now()
+}
+
+// idle reports whether the room has no listeners and has been quiet longer
+// than roomIdleTimeout
+func (rm *Room) idle() bool {
+	if rm.connCount() > 0 {
+		return false
+	}
+	rm.lastActiveMutex.RLock()
+	defer rm.lastActiveMutex.RUnlock()
+	return time.Since(rm.lastActive) > roomIdleTimeout
+}
+
+// connCount returns the number of connections currently listening to the
+// room, across every transport
+func (rm *Room) connCount() int {
+	rm.subsMutex.RLock()
+	defer rm.subsMutex.RUnlock()
+	return len(rm.subs)
+}
+
+// sendCount notifies every subscriber of the room's current listener count,
+// evicting any that have fallen too far behind
+func (rm *Room) sendCount() {
+	n := rm.connCount()
+	rm.broadcast(func(sub Subscriber) bool {
+		return sub.Count(n)
+	})
+}
+
+// send persists message as a new update and broadcasts it to every
+// subscriber, regardless of transport, evicting any that have fallen too
+// far behind
+func (rm *Room) send(timestamp, message string) error {
+	update, err := rm.store.Append(rm.name, timestamp, message)
+	if err != nil {
+		return err
+	}
+	rm.touch()
+	rm.broadcast(func(sub Subscriber) bool {
+		return sub.Update(update)
+	})
+	return nil
+}
+
+// broadcast calls deliver for every subscriber and evicts any that have now
+// failed missLimit consecutive deliveries, closing their connection so they
+// reconnect and resync from history
+func (rm *Room) broadcast(deliver func(Subscriber) bool) {
+	rm.subsMutex.RLock()
+	subs := make([]Subscriber, 0, len(rm.subs))
+	for sub := range rm.subs {
+		subs = append(subs, sub)
+	}
+	rm.subsMutex.RUnlock()
+
+	var evicted []Subscriber
+	for _, sub := range subs {
+		if !deliver(sub) && rm.stats != nil {
+			rm.stats.addDroppedMessage()
+		}
+		if sub.Unhealthy() {
+			evicted = append(evicted, sub)
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	rm.subsMutex.Lock()
+	removed := evicted[:0]
+	for _, sub := range evicted {
+		// A concurrent broadcast may already have evicted it
+		if _, ok := rm.subs[sub]; ok {
+			delete(rm.subs, sub)
+			removed = append(removed, sub)
+		}
+	}
+	rm.subsMutex.Unlock()
+	for _, sub := range removed {
+		sub.Close()
+		if rm.stats != nil {
+			rm.stats.addEvictedClient()
 		}
 	}
 }
 
-// sendHistory sends chat log to a client
-func (a *App) sendHistory(w http.ResponseWriter) error {
-	fmtstr := "<div><p>%s</p><time>%s</time></div>"
-	a.historyMutex.RLock()
-	defer a.historyMutex.RUnlock()
-	for _, update := range a.history {
-		msg := fmt.Sprintf(fmtstr, update.message, update.timestamp)
+// sendHistory sends updates newer than sinceID to a client as rendered
+// HTML. A sinceID of 0 replays the last historyLimit updates. If the
+// replay was capped at sinceLimit updates, a trailing notice links the
+// client to the next page.
+func (rm *Room) sendHistory(w http.ResponseWriter, sinceID int64) error {
+	updates, truncated, err := rm.historySince(sinceID)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		fmtstr := "<div><p>%s</p><time>%s</time></div>"
+		msg := fmt.Sprintf(fmtstr, template.HTMLEscapeString(update.message), update.timestamp)
 		_, err := w.Write([]byte(msg))
 		if err != nil {
 			return err
 		}
 	}
+	if truncated && len(updates) > 0 {
+		last := updates[len(updates)-1]
+		fmtstr := "<div class=\"truncated\">History truncated; <a href=\"?since=%d\">continue from here</a></div>"
+		_, err := w.Write([]byte(fmt.Sprintf(fmtstr, last.id)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRawHistory sends updates newer than sinceID to a client as plain text
+// lines. A sinceID of 0 replays the last historyLimit updates. If the
+// replay was capped at sinceLimit updates, a trailing comment line tells
+// the client how to fetch the next page.
+func (rm *Room) sendRawHistory(w io.Writer, sinceID int64) error {
+	updates, truncated, err := rm.historySince(sinceID)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		_, err := w.Write([]byte(rawUpdate(update)))
+		if err != nil {
+			return err
+		}
+	}
+	if truncated && len(updates) > 0 {
+		last := updates[len(updates)-1]
+		_, err := w.Write([]byte(fmt.Sprintf("# truncated, reconnect with ?since=%d for more\n", last.id)))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// addChan adds a client chan to listeners
-func (a *App) addChan(ch chan []byte) {
-	a.chansMutex.Lock()
-	defer a.chansMutex.Unlock()
-	a.chans[ch] = struct{}{}
+// historySince returns the updates to replay for a client resuming from
+// sinceID, falling back to the last historyLimit updates when sinceID is 0.
+// The bool reports whether more updates exist beyond what's returned, for
+// the caller to tell the client how to keep paging forward.
+func (rm *Room) historySince(sinceID int64) ([]*Update, bool, error) {
+	if sinceID > 0 {
+		return rm.store.Since(rm.name, sinceID)
+	}
+	updates, err := rm.store.Latest(rm.name, historyLimit)
+	return updates, false, err
+}
+
+// htmlUpdate renders an *Update as the HTML snippet sent to browser clients
+func htmlUpdate(update *Update) string {
+	fmtstr := "<div class=\"new\"><p>%s</p><time>%s</time></div>"
+	return fmt.Sprintf(fmtstr, template.HTMLEscapeString(update.message), update.timestamp)
+}
+
+// rawUpdate renders an *Update as a "timestamp\tmessage\n" line for the
+// plain text transport
+func rawUpdate(update *Update) string {
+	msg := strings.ReplaceAll(update.message, "\n", " ")
+	msg = strings.ReplaceAll(msg, "\t", " ")
+	return fmt.Sprintf("%s\t%s\n", update.timestamp, msg)
+}
+
+// addSub registers sub as a listener of the room
+func (rm *Room) addSub(sub Subscriber) {
+	rm.touch()
+	rm.subsMutex.Lock()
+	defer rm.subsMutex.Unlock()
+	rm.subs[sub] = struct{}{}
 }
 
-// removeChan removes a client chan from listeners
-func (a *App) removeChan(ch chan []byte) {
-	a.chansMutex.Lock()
-	defer a.chansMutex.Unlock()
-	delete(a.chans, ch)
+// removeSub removes sub from the room's listeners
+func (rm *Room) removeSub(sub Subscriber) {
+	rm.subsMutex.Lock()
+	defer rm.subsMutex.Unlock()
+	delete(rm.subs, sub)
 }
 
+// htmlSub is the Subscriber for the no-JS chunked-HTML transport. Its chan
+// carries pre-rendered HTML snippets so the getHandler loop only has to
+// write bytes.
+type htmlSub struct {
+	backpressure
+	ch chan []byte
+}
+
+// Update implements Subscriber
+func (s *htmlSub) Update(update *Update) bool {
+	return s.guardedSend(func() bool {
+		return nonBlockingSend(s.ch, []byte(htmlUpdate(update)))
+	})
+}
+
+// Count implements Subscriber
+func (s *htmlSub) Count(n int) bool {
+	return s.guardedSend(func() bool {
+		fmtstr := "<style>#nc::before{content:\"%d\"}</style>"
+		return nonBlockingSend(s.ch, []byte(fmt.Sprintf(fmtstr, n)))
+	})
+}
+
+// Close implements Subscriber
+func (s *htmlSub) Close() {
+	s.guardedClose(func() { close(s.ch) })
+}
+
+// rawSub is the Subscriber for the text/plain transport used by CLI
+// clients. It has no use for count frames, since plain readers only care
+// about the message stream.
+type rawSub struct {
+	backpressure
+	ch chan *Update
+}
+
+// Update implements Subscriber
+func (s *rawSub) Update(update *Update) bool {
+	return s.guardedSend(func() bool {
+		select {
+		case s.ch <- update:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+// Count implements Subscriber
+func (s *rawSub) Count(n int) bool { return true }
+
+// Close implements Subscriber
+func (s *rawSub) Close() {
+	s.guardedClose(func() { close(s.ch) })
+}
+
+// nonBlockingSend delivers data to ch, reporting false if ch's buffer is
+// full rather than blocking the broadcaster on a slow subscriber
+func nonBlockingSend(ch chan []byte, data []byte) bool {
+	select {
+	case ch <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// App represents the main application
+type App struct {
+	store   HistoryStore
+	limiter *RateLimiter
+	stats   Stats
+
+	roomsMutex sync.RWMutex
+	rooms      map[string]*Room
+}
+
+// NewApp returns a new *App whose rooms persist history to store and whose
+// POSTs are throttled by limiter
+func NewApp(store HistoryStore, limiter *RateLimiter) *App {
+	return &App{
+		store:   store,
+		limiter: limiter,
+		rooms:   make(map[string]*Room),
+	}
+}
+
+// room returns the named room, creating it if it doesn't already exist
+func (a *App) room(name string) *Room {
+	a.roomsMutex.RLock()
+	rm, ok := a.rooms[name]
+	a.roomsMutex.RUnlock()
+	if ok {
+		return rm
+	}
+	a.roomsMutex.Lock()
+	defer a.roomsMutex.Unlock()
+	rm, ok = a.rooms[name]
+	if ok {
+		return rm
+	}
+	rm = NewRoom(name, a.store, &a.stats)
+	a.rooms[name] = rm
+	return rm
+}
+
+// gcRooms periodically evicts rooms that have been idle too long. It's meant
+// to be run in its own goroutine for the lifetime of the app.
+func (a *App) gcRooms() {
+	for range time.Tick(roomGCInterval) {
+		a.roomsMutex.Lock()
+		for name, rm := range a.rooms {
+			if name != "" && rm.idle() {
+				delete(a.rooms, name)
+			}
+		}
+		a.roomsMutex.Unlock()
+	}
+}
+
+// roomName extracts the room name addressed by the request path. The root
+// path "/" maps to the default room, and "/room/foo" maps to room "foo".
+func roomName(path string) string {
+	if !strings.HasPrefix(path, roomPrefix) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(path, roomPrefix), "/")
+}
+
+// wantsRaw reports whether a GET request asked for the plain text
+// transport via its Accept header
+func wantsRaw(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html")
+}
+
+// wantsRawPost reports whether a POST should be treated as the text/plain
+// transport rather than the HTML form. An explicit text/plain Content-Type
+// always means raw. Otherwise, curl's default Content-Type for `curl -d` is
+// application/x-www-form-urlencoded, which by header alone is
+// indistinguishable from the HTML form's own submission, so the real
+// signal is whether body actually decodes to the form's "msg" field at
+// all, not whether that field is empty: an empty textarea submits "msg=",
+// which must still reach postHandler's own empty-message handling rather
+// than being published verbatim as raw text.
+func wantsRawPost(r *http.Request, body []byte) bool {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+		return true
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return true
+	}
+	return !values.Has("msg")
+}
+
+// Path suffix that upgrades a connection to the WebSocket transport, e.g.
+// /ws for the default room or /room/foo/ws for room "foo"
+const wsSuffix = "/ws"
+
 // handler is main HTTP entry point for requests
 func (a *App) handler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if path == wsSuffix || (strings.HasPrefix(path, roomPrefix) && strings.HasSuffix(path, wsSuffix)) {
+		rm := a.room(roomName(strings.TrimSuffix(path, wsSuffix)))
+		a.wsHandler(rm, w, r)
+		return
+	}
+	rm := a.room(roomName(path))
 	if r.Method == "GET" {
-		a.getHandler(w, r)
+		if wantsRaw(r) {
+			a.getRawHandler(rm, w, r)
+			return
+		}
+		a.getHandler(rm, w, r)
 		return
 	} else if r.Method == "POST" {
-		a.postHandler(w, r)
+		body, err := io.ReadAll(io.LimitReader(r.Body, postBodyLimit+1))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if wantsRawPost(r, body) {
+			a.postRawHandler(rm, w, r, body)
+			return
+		}
+		a.postHandler(rm, w, r, body)
 		return
 	}
 }
 
+// sinceID parses the replay cursor from the ?since= query parameter or, if
+// absent, the Last-Event-ID header, mirroring the convention used by SSE
+// clients to resume a dropped stream
+func sinceID(r *http.Request) int64 {
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		raw = r.Header.Get("Last-Event-ID")
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
 // getHandler handles main page
-func (a *App) getHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) getHandler(rm *Room, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -169,22 +601,28 @@ func (a *App) getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Create and register connection channel
 	ch := make(chan []byte, bufferSize)
-	a.addChan(ch)
+	sub := &htmlSub{ch: ch}
+	rm.addSub(sub)
 	defer func() {
-		a.removeChan(ch)
-		a.sendCount()
+		rm.removeSub(sub)
+		rm.sendCount()
 	}()
 	// Write page head and history
 	w.Write([]byte(pageHead))
-	err := a.sendHistory(w)
+	err := rm.sendHistory(w, sinceID(r))
 	if err != nil {
 		return
 	}
 	flusher.Flush()
-	a.sendCount()
+	rm.sendCount()
 	for {
 		select {
-		case msg := <-ch:
+		case msg, ok := <-ch:
+			if !ok {
+				// Evicted for falling too far behind; reconnecting will
+				// resync from history.
+				return
+			}
 			_, err = w.Write(msg)
 			if err != nil {
 				return
@@ -199,19 +637,96 @@ func (a *App) getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// postHandler handles new chat posts
-func (a *App) postHandler(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	msg := r.PostForm.Get("msg")
+// postHandler handles new chat posts from the HTML form
+func (a *App) postHandler(rm *Room, w http.ResponseWriter, r *http.Request, body []byte) {
+	if !a.limiter.Allow(remoteIP(r)) {
+		a.stats.addRateLimitedPost()
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	msg := strings.TrimSpace(values.Get("msg"))
+	if len(msg) == 0 {
+		http.Error(w, "empty message", http.StatusBadRequest)
+		return
+	}
+	if len(msg) > maxMsgLen {
+		http.Error(w, "message too long", http.StatusBadRequest)
+		return
+	}
+	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05")
+	if err := rm.send(timestamp, msg); err != nil {
+		log.Println("send:", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, r.URL.Path, 302)
+}
+
+// getRawHandler streams the room as "timestamp\tmessage\n" lines, replaying
+// history before switching to live updates
+func (a *App) getRawHandler(rm *Room, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	ch := make(chan *Update, bufferSize)
+	sub := &rawSub{ch: ch}
+	rm.addSub(sub)
+	defer rm.removeSub(sub)
+	err := rm.sendRawHistory(w, sinceID(r))
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case update, ok := <-ch:
+			if !ok {
+				// Evicted for falling too far behind; reconnecting will
+				// resync from history.
+				return
+			}
+			_, err = w.Write([]byte(rawUpdate(update)))
+			if err != nil {
+				return
+			}
+		case <-time.After(pingRate):
+			_, err := w.Write([]byte("\n"))
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// postRawHandler handles new chat posts from raw bodies, e.g. `curl -d
+// "hello" http://host/`
+func (a *App) postRawHandler(rm *Room, w http.ResponseWriter, r *http.Request, body []byte) {
+	if !a.limiter.Allow(remoteIP(r)) {
+		a.stats.addRateLimitedPost()
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) == 0 {
+		http.Error(w, "empty message", http.StatusBadRequest)
+		return
+	}
 	if len(msg) > maxMsgLen {
-		http.Redirect(w, r, "/", 302)
+		http.Error(w, "message too long", http.StatusBadRequest)
 		return
 	}
-	msg = template.HTMLEscapeString(msg)
-	msg = strings.TrimSpace(msg)
-	if len(msg) > 0 {
-		timestamp := time.Now().UTC().Format("2006-01-02 15:04:05")
-		a.send(&Update{timestamp: timestamp, message: msg})
+	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05")
+	if err := rm.send(timestamp, msg); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
-	http.Redirect(w, r, "/", 302)
+	w.WriteHeader(http.StatusOK)
 }
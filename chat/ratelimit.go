@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long an IP's bucket is kept after its last request before being
+// garbage-collected
+const bucketIdleTimeout = 10 * time.Minute
+
+// tokenBucket is a classic token-bucket: tokens refill at rate per second up
+// to burst, and each allowed request consumes one
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token-bucket rate limiter keyed by remote IP
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	rate    float64
+	burst   float64
+}
+
+// NewRateLimiter returns a *RateLimiter that allows rate requests per second
+// per key, up to burst at once
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		rate:    rate,
+		burst:   float64(burst),
+	}
+}
+
+// Allow reports whether a request keyed by key should proceed, consuming a
+// token if so
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// gc periodically evicts buckets for IPs that haven't made a request in a
+// while. It's meant to be run in its own goroutine for the lifetime of the
+// limiter.
+func (rl *RateLimiter) gc() {
+	for range time.Tick(bucketIdleTimeout) {
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if time.Since(b.last) > bucketIdleTimeout {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// remoteIP returns the request's remote IP with any port stripped
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
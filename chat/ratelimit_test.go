@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRateLimiterAllowsUpToBurst(t *testing.T) {
+	rl := NewRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !rl.Allow("1.2.3.4") {
+			t.Fatalf("want request %d within burst to be allowed", i)
+		}
+	}
+	if rl.Allow("1.2.3.4") {
+		t.Fatal("want request beyond burst to be denied")
+	}
+}
+
+func TestRateLimiterTracksKeysIndependently(t *testing.T) {
+	rl := NewRateLimiter(1, 1)
+	if !rl.Allow("1.2.3.4") {
+		t.Fatal("want first request for a key to be allowed")
+	}
+	if !rl.Allow("5.6.7.8") {
+		t.Fatal("want a different key's burst to be unaffected by another key")
+	}
+	if rl.Allow("1.2.3.4") {
+		t.Fatal("want the first key's burst to still be exhausted")
+	}
+}
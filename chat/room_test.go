@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeHistoryStore is a minimal HistoryStore that stores nothing, for tests
+// that only care about Room's delivery/eviction behavior, not persistence.
+type fakeHistoryStore struct{}
+
+func (fakeHistoryStore) Append(room, timestamp, message string) (*Update, error) {
+	return &Update{timestamp: timestamp, message: message}, nil
+}
+func (fakeHistoryStore) Since(room string, sinceID int64) ([]*Update, bool, error) {
+	return nil, false, nil
+}
+func (fakeHistoryStore) Latest(room string, n int) ([]*Update, error) { return nil, nil }
+func (fakeHistoryStore) Close() error                                 { return nil }
+
+// blockingSub is a Subscriber whose deliveries always fail, so it goes
+// Unhealthy (and becomes eligible for eviction) after its first missLimit
+// broadcasts, making it a reliable way to race delivery against eviction.
+type blockingSub struct {
+	backpressure
+	closes int32
+}
+
+func (s *blockingSub) Update(update *Update) bool { return s.guardedSend(func() bool { return false }) }
+func (s *blockingSub) Count(n int) bool           { return s.guardedSend(func() bool { return false }) }
+func (s *blockingSub) Close()                     { s.guardedClose(func() { atomic.AddInt32(&s.closes, 1) }) }
+
+// TestRoomBroadcastConcurrentEviction runs many posts concurrently against
+// a single never-delivering subscriber, so every broadcast call races to
+// evict it. guardedSend/guardedClose must serialize delivery against Close
+// so the subscriber is closed exactly once and no send runs after it's been
+// torn down; run with -race to catch any regression.
+func TestRoomBroadcastConcurrentEviction(t *testing.T) {
+	rm := NewRoom("test", fakeHistoryStore{}, &Stats{})
+	sub := &blockingSub{}
+	rm.addSub(sub)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rm.send("t", "msg"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&sub.closes); got != 1 {
+		t.Fatalf("want exactly one Close, got %d", got)
+	}
+}
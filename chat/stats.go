@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats holds process-wide observability counters, each safe for
+// concurrent use
+type Stats struct {
+	droppedMessages  int64
+	evictedClients   int64
+	rateLimitedPosts int64
+}
+
+// statsSnapshot is the JSON-serializable view of Stats returned by
+// statsHandler
+type statsSnapshot struct {
+	DroppedMessages  int64 `json:"dropped_messages"`
+	EvictedClients   int64 `json:"evicted_clients"`
+	RateLimitedPosts int64 `json:"rate_limited_posts"`
+}
+
+func (s *Stats) addDroppedMessage()  { atomic.AddInt64(&s.droppedMessages, 1) }
+func (s *Stats) addEvictedClient()   { atomic.AddInt64(&s.evictedClients, 1) }
+func (s *Stats) addRateLimitedPost() { atomic.AddInt64(&s.rateLimitedPosts, 1) }
+
+func (s *Stats) snapshot() statsSnapshot {
+	return statsSnapshot{
+		DroppedMessages:  atomic.LoadInt64(&s.droppedMessages),
+		EvictedClients:   atomic.LoadInt64(&s.evictedClients),
+		RateLimitedPosts: atomic.LoadInt64(&s.rateLimitedPosts),
+	}
+}
+
+// statsHandler serves the current counters as JSON
+func (a *App) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(a.stats.snapshot())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrader upgrades a /ws request to a WebSocket connection. Origin checking
+// is left to whatever reverse proxy fronts the app, matching the rest of
+// this package's lack of auth.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsUpdateFrame is the JSON frame sent for a newly posted message
+type wsUpdateFrame struct {
+	Timestamp string `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// wsCountFrame is the JSON frame sent whenever the listener count changes
+type wsCountFrame struct {
+	Count int `json:"count"`
+}
+
+// wsInbound is the JSON frame a client posts to publish a message
+type wsInbound struct {
+	Message string `json:"message"`
+}
+
+// wsTruncatedFrame is sent when history replay was capped at sinceLimit
+// updates; the client can reconnect with ?since=LastID to page forward
+type wsTruncatedFrame struct {
+	LastID int64 `json:"last_id"`
+}
+
+// wsSub is the Subscriber for the WebSocket transport. Its chan carries
+// pre-marshaled JSON frames.
+type wsSub struct {
+	backpressure
+	ch chan []byte
+}
+
+// Update implements Subscriber
+func (s *wsSub) Update(update *Update) bool {
+	data, err := json.Marshal(wsUpdateFrame{Timestamp: update.timestamp, Message: update.message})
+	if err != nil {
+		return true
+	}
+	return s.guardedSend(func() bool {
+		return nonBlockingSend(s.ch, data)
+	})
+}
+
+// Count implements Subscriber
+func (s *wsSub) Count(n int) bool {
+	data, err := json.Marshal(wsCountFrame{Count: n})
+	if err != nil {
+		return true
+	}
+	return s.guardedSend(func() bool {
+		return nonBlockingSend(s.ch, data)
+	})
+}
+
+// Close implements Subscriber
+func (s *wsSub) Close() {
+	s.guardedClose(func() { close(s.ch) })
+}
+
+// Truncated notifies the client that history replay was capped at lastID,
+// so it can keep paging forward by reconnecting with ?since=lastID
+func (s *wsSub) Truncated(lastID int64) bool {
+	data, err := json.Marshal(wsTruncatedFrame{LastID: lastID})
+	if err != nil {
+		return true
+	}
+	return s.guardedSend(func() bool {
+		return nonBlockingSend(s.ch, data)
+	})
+}
+
+// wsHandler upgrades the connection and relays the room's Update stream as
+// JSON frames, while also accepting published messages from the client
+func (a *App) wsHandler(rm *Room, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ch := make(chan []byte, bufferSize)
+	sub := &wsSub{ch: ch}
+	rm.addSub(sub)
+	defer func() {
+		rm.removeSub(sub)
+		rm.sendCount()
+	}()
+
+	// History is written straight to conn rather than through ch, whose
+	// bufferSize slots would drop all but the first few replayed frames
+	updates, truncated, err := rm.historySince(sinceID(r))
+	if err == nil {
+		for _, update := range updates {
+			if err := conn.WriteJSON(wsUpdateFrame{Timestamp: update.timestamp, Message: update.message}); err != nil {
+				return
+			}
+		}
+		if truncated && len(updates) > 0 {
+			if err := conn.WriteJSON(wsTruncatedFrame{LastID: updates[len(updates)-1].id}); err != nil {
+				return
+			}
+		}
+	}
+	rm.sendCount()
+
+	done := make(chan struct{})
+	go a.wsReadLoop(rm, conn, remoteIP(r), done)
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				// Evicted for falling too far behind; reconnecting will
+				// resync from history.
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsReadLoop reads published messages off conn until it closes, broadcasting
+// each one to the room (subject to the same per-IP rate limit as the other
+// transports), then closes done
+func (a *App) wsReadLoop(rm *Room, conn *websocket.Conn, ip string, done chan struct{}) {
+	defer close(done)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if !a.limiter.Allow(ip) {
+			a.stats.addRateLimitedPost()
+			continue
+		}
+		var in wsInbound
+		if err := json.Unmarshal(data, &in); err != nil {
+			continue
+		}
+		msg := strings.TrimSpace(in.Message)
+		if len(msg) == 0 || len(msg) > maxMsgLen {
+			continue
+		}
+		timestamp := time.Now().UTC().Format("2006-01-02 15:04:05")
+		if err := rm.send(timestamp, msg); err != nil {
+			log.Println("ws send:", err)
+		}
+	}
+}
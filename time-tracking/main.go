@@ -6,9 +6,14 @@
 package main
 
 import (
+	"flag"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Time between updates to keep alive
@@ -16,46 +21,40 @@ import (
 // Longer: does the opposite of both
 const timeout = time.Second
 
+// How long the server waits to read request headers before closing the
+// connection, so a slow-loris favicon client can't hold it open forever
+const readHeaderTimeout = 5 * time.Second
+
 func main() {
-	http.HandleFunc("/favicon.ico", favicon)
-	http.HandleFunc("/page1", page("Page 1"))
-	http.HandleFunc("/page2", page("Page 2"))
-	http.HandleFunc("/", page("Index"))
-	log.Println("Serving on :8080")
-	http.ListenAndServe(":8080", nil)
-}
+	addr := flag.String("addr", ":8080", "address to listen on")
+	sinkPath := flag.String("sink", "", "path to append completed session JSON lines to (default stdout)")
+	flag.Parse()
 
-// Handler /favicon.ico requests
-func favicon(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		return
-	}
-	t0 := time.Now()
-	page := r.Header.Get("referer")
-	log.Println("IN", page)
-	// When connection closes this deferred will call
-	defer func() {
-		t1 := time.Now()
-		log.Println("OUT", page, t1.Sub(t0))
-	}()
-	// Disable caching
-	w.Header().Set("Content-Type", "image/x-icon")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
-	// Setup chunked transfer encoding
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		return
-	}
-	for {
-		time.Sleep(timeout)
-		_, err := w.Write([]byte{0})
+	sink := io.Writer(os.Stdout)
+	if *sinkPath != "" {
+		f, err := os.OpenFile(*sinkPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			return
+			log.Fatal(err)
 		}
-		flusher.Flush()
+		defer f.Close()
+		sink = f
+	}
+	tracker := NewTracker(sink)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", tracker.favicon)
+	mux.HandleFunc("/page1", page("Page 1"))
+	mux.HandleFunc("/page2", page("Page 2"))
+	mux.HandleFunc("/", page("Index"))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
 	}
+	log.Println("Serving on", *addr)
+	log.Fatal(server.ListenAndServe())
 }
 
 // Create page handler with title
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Name of the cookie used to correlate a visitor's favicon connection across
+// requests
+const sessionCookie = "tracker_session"
+
+var (
+	pageVisitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "page_visits_total",
+		Help: "Total number of page visits observed via the favicon tracker.",
+	}, []string{"page"})
+
+	pageVisitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "page_visit_duration_seconds",
+		Help: "Distribution of page dwell time observed via the favicon tracker.",
+	}, []string{"page"})
+
+	activeVisitors = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "active_visitors",
+		Help: "Number of visitors currently holding an open favicon connection, per page.",
+	}, []string{"page"})
+)
+
+// Session describes one completed page visit, as written to the sink
+type Session struct {
+	ID         string        `json:"id"`
+	Page       string        `json:"page"`
+	RemoteAddr string        `json:"remote_addr"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration_ns"`
+}
+
+// sessionKey identifies one active favicon connection. The session cookie
+// alone isn't enough: it's shared across every tab a visitor has open, so a
+// second page opened under the same cookie must not collide with (and
+// silently orphan) the first page's still-open session.
+type sessionKey struct {
+	id   string
+	page string
+}
+
+// Tracker holds active visit sessions, keyed by sessionKey, and exports
+// their lifecycle as Prometheus metrics and completed-session JSON lines
+type Tracker struct {
+	mu       sync.Mutex
+	sessions map[sessionKey]*Session
+
+	sinkMu sync.Mutex
+	sink   io.Writer
+}
+
+// NewTracker returns a new *Tracker that writes completed sessions to sink
+func NewTracker(sink io.Writer) *Tracker {
+	return &Tracker{
+		sessions: make(map[sessionKey]*Session),
+		sink:     sink,
+	}
+}
+
+// newSessionID returns a random hex session identifier
+func newSessionID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// begin records the start of a new session for page, identified by id
+func (t *Tracker) begin(id, page, remoteAddr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions[sessionKey{id, page}] = &Session{
+		ID:         id,
+		Page:       page,
+		RemoteAddr: remoteAddr,
+		Start:      time.Now(),
+	}
+	pageVisitsTotal.WithLabelValues(page).Inc()
+	activeVisitors.WithLabelValues(page).Inc()
+}
+
+// end closes the session for (id, page), recording its duration and
+// writing it to the sink
+func (t *Tracker) end(id, page string) {
+	key := sessionKey{id, page}
+	t.mu.Lock()
+	session, ok := t.sessions[key]
+	if ok {
+		delete(t.sessions, key)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	session.Duration = time.Since(session.Start)
+	pageVisitDuration.WithLabelValues(session.Page).Observe(session.Duration.Seconds())
+	activeVisitors.WithLabelValues(session.Page).Dec()
+	t.writeSession(session)
+}
+
+// writeSession appends session to the sink as a single line of JSON
+func (t *Tracker) writeSession(session *Session) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		log.Println("tracker: marshal session:", err)
+		return
+	}
+	t.sinkMu.Lock()
+	defer t.sinkMu.Unlock()
+	if _, err := t.sink.Write(append(data, '\n')); err != nil {
+		log.Println("tracker: write session:", err)
+	}
+}
+
+// favicon handles /favicon.ico requests, keeping the connection open for the
+// duration of the page visit
+func (t *Tracker) favicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		return
+	}
+	page := r.Header.Get("Referer")
+	id := sessionID(w, r)
+	t.begin(id, page, r.RemoteAddr)
+	defer t.end(id, page)
+	// Disable caching
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	// Setup chunked transfer encoding
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := w.Write([]byte{0})
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// sessionID reads the tracker's session cookie from the request, issuing a
+// fresh one on the response if it isn't already set
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	id := newSessionID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return id
+}